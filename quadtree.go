@@ -2,7 +2,15 @@
 package quadtree
 
 import (
+	"bytes"
+	"container/heap"
+	"encoding"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
 )
 
 // Quadtree is a dynamically resizable struct that offers
@@ -24,6 +32,14 @@ type Quadtree struct {
 	// The total number of items in this Quadtree.
 	size            int
 	debugAssertions bool
+	// Recycles *node values freed by Remove/collapse and Clear, so churning
+	// workloads (continuous Add/Remove) don't generate garbage on every split.
+	// *node is pointer-shaped, so handing one to sync.Pool doesn't itself
+	// allocate; a node's small item buffer (node.itemsBuf) is recycled along
+	// with it, rather than pooled separately as a []treeEntry, since boxing a
+	// slice value into the interface{} sync.Pool expects would allocate on
+	// every Get/Put and erase the saving for a tree that's only ever grown.
+	nodePool sync.Pool
 }
 
 type Point struct {
@@ -50,16 +66,35 @@ type node struct {
 	bounds Rect
 	// The depth this node is at. Root node is at depth 0.
 	depth int
-	// The entries that are inside this node
+	// The entries that are inside this node. For a freshly acquired leaf,
+	// this is itemsBuf[:0]; it only spills onto a separately allocated
+	// array once it grows past itemsBuf's capacity.
 	items []treeEntry
+	// Backing array for items, embedded so it's recycled along with the
+	// node itself by nodePool instead of needing its own pool.
+	itemsBuf [4]treeEntry
 	// The four child nodes of this node (one node per quadrant).
 	ul, ur, ll, lr *node
+	// The node that this node was split from. Nil for the root node.
+	parent *node
+	// Rect-valued entries added via AddRect that are fully contained by
+	// this node's bounds. On a branch, an entry is kept here when no child
+	// existing at the time of the AddRect call fully contained its rect; a
+	// leaf keeps every entry that reaches it. Entries are not moved down
+	// when this node later splits. Unlike items, these live here whether
+	// the node is a leaf or a branch, and don't count toward
+	// maxItemsPerNode.
+	rectItems []treeEntry
 }
 
 // An individual entry in the tree.
 type treeEntry struct {
 	position Point
 	data     interface{}
+	// rect is only set for entries added via AddRect, which are stored in a
+	// node's rectItems (rather than items) and queried using rect overlap
+	// rather than position containment.
+	rect Rect
 }
 
 type consumer func(treeEntry) bool
@@ -71,21 +106,83 @@ func NewQuadtree(bounds Rect, maxDepth, maxItemsPerNode int) (*Quadtree, error)
 	if maxItemsPerNode <= 0 {
 		return nil, fmt.Errorf("Creating tree failed: maxItemsPerNode must be larger than 0")
 	}
-	return &Quadtree{
+	qt := &Quadtree{
 		maxDepth:        maxDepth,
 		maxItemsPerNode: maxItemsPerNode,
-		root: &node{
-			bounds: bounds,
-			depth:  0,
-			items:  make([]treeEntry, 0, 4),
-		},
-	}, nil
+	}
+	qt.nodePool.New = func() interface{} { return &node{} }
+	qt.root = &node{
+		bounds: bounds,
+		depth:  0,
+	}
+	qt.root.items = qt.root.itemsBuf[:0]
+	return qt, nil
 }
 
 func (qt *Quadtree) Size() int {
 	return qt.size
 }
 
+// Removes every item from the tree, returning all freed nodes, along with
+// their embedded item buffers, to the node pool for reuse by later Add calls.
+func (qt *Quadtree) Clear() {
+	freeChildren(qt, qt.root)
+	qt.root.items = qt.root.itemsBuf[:0]
+	qt.root.rectItems = nil
+	qt.size = 0
+}
+
+// Returns n's children (and everything beneath them) to the pool, leaving
+// n itself and its bounds/depth/parent untouched.
+func freeChildren(qt *Quadtree, n *node) {
+	if n.items != nil {
+		// n is a leaf: nothing below it.
+		n.items = nil
+		return
+	}
+	freeNode(qt, n.ul)
+	freeNode(qt, n.ur)
+	freeNode(qt, n.ll)
+	freeNode(qt, n.lr)
+	n.ul, n.ur, n.ll, n.lr = nil, nil, nil, nil
+}
+
+// Recursively returns n, and everything beneath it, to the pool.
+func freeNode(qt *Quadtree, n *node) {
+	if n == nil {
+		return
+	}
+	if n.items == nil {
+		freeNode(qt, n.ul)
+		freeNode(qt, n.ur)
+		freeNode(qt, n.ll)
+		freeNode(qt, n.lr)
+	}
+	qt.putNode(n)
+}
+
+// Returns a *node from the pool, ready to be populated by the caller.
+func (qt *Quadtree) getNode() *node {
+	return qt.nodePool.Get().(*node)
+}
+
+// Resets n and returns it to the pool for reuse by a future split. itemsBuf
+// is cleared slot by slot so a removed item's data doesn't stay reachable
+// (and thus unreclaimed by the GC) just because it's still sitting in the
+// backing array of a pooled node.
+func (qt *Quadtree) putNode(n *node) {
+	n.bounds = Rect{}
+	n.depth = 0
+	n.items = nil
+	for i := range n.itemsBuf {
+		n.itemsBuf[i] = treeEntry{}
+	}
+	n.ul, n.ur, n.ll, n.lr = nil, nil, nil, nil
+	n.parent = nil
+	n.rectItems = nil
+	qt.nodePool.Put(n)
+}
+
 // Returns the objects within the given bounds
 func (qt *Quadtree) Query(bounds Rect) []interface{} {
 	items := make([]interface{}, 0, 10)
@@ -96,28 +193,217 @@ func (qt *Quadtree) Query(bounds Rect) []interface{} {
 	return items
 }
 
-// This will recurse down the tree, removing the nodes that
-// have no overlap with the given bounds. When all overlapping
-// nodes are found, their items are returned.
-func queryInternal(node *node, bounds Rect, consumer consumer) {
-	if overlaps(node.bounds, bounds) {
-		if node.items == nil {
-			// This node has no items, but it has children. Keep recursing.
-			queryInternal(node.ul, bounds, consumer)
-			queryInternal(node.ur, bounds, consumer)
-			queryInternal(node.ll, bounds, consumer)
-			queryInternal(node.lr, bounds, consumer)
-		} else {
-			// We reached an end node. Since this node may only be partially
-			// overlapping, ensure each item is inside bounds before consuming.
-			for _, e := range node.items {
-				if bounds.Contains(e.position) {
-					// TODO: handle the return value and exit
-					consumer(e)
+// Like Query, but streams matches to fn as they're found instead of
+// allocating a result slice, and stops scanning the tree as soon as fn
+// returns false.
+func (qt *Quadtree) QueryIterative(bounds Rect, fn func(data interface{}, pos Point) bool) {
+	queryInternal(qt.root, bounds, func(item treeEntry) bool {
+		return fn(item.data, item.position)
+	})
+}
+
+// This will recurse down the tree, skipping the nodes that have no overlap
+// with the given bounds. When all overlapping nodes are found, their items
+// are passed to consumer. The return value propagates consumer's own return
+// value back up through every recursive call: once consumer returns false,
+// every caller up the chain stops visiting further nodes and also returns
+// false, so a QueryIterative consumer can abandon a scan mid-traversal.
+func queryInternal(n *node, bounds Rect, consumer consumer) bool {
+	if !overlaps(n.bounds, bounds) {
+		return true
+	}
+
+	// rectItems can live on this node whether it's a leaf or a branch, so
+	// check them regardless of which case below applies.
+	for _, e := range n.rectItems {
+		if overlaps(e.rect, bounds) {
+			if !consumer(e) {
+				return false
+			}
+		}
+	}
+
+	if n.items == nil {
+		// This node has no items, but it has children. Keep recursing.
+		for _, child := range [4]*node{n.ul, n.ur, n.ll, n.lr} {
+			if !queryInternal(child, bounds, consumer) {
+				return false
+			}
+		}
+	} else {
+		// We reached an end node. Since this node may only be partially
+		// overlapping, ensure each item is inside bounds before consuming.
+		for _, e := range n.items {
+			if bounds.Contains(e.position) {
+				if !consumer(e) {
+					return false
 				}
 			}
 		}
 	}
+	return true
+}
+
+// Returns the k items closest to p, sorted ascending by distance to p.
+// If the tree holds fewer than k items, all of them are returned.
+func (qt *Quadtree) KNearest(p Point, k int) []interface{} {
+	return knearestInternal(qt, p, k, math.Inf(1))
+}
+
+// Like KNearest, but discards any candidate further than maxDist from p.
+func (qt *Quadtree) KNearestWithin(p Point, k int, maxDist float64) []interface{} {
+	return knearestInternal(qt, p, k, maxDist*maxDist)
+}
+
+// A node queued for a best-first search, along with the squared distance
+// from the search point to the node's bounds (0 if the point is inside).
+type nodeDistance struct {
+	n      *node
+	distSq float64
+}
+
+// Min-heap of nodeDistance, ordered by ascending distance to the search
+// point, so the closest unexplored node is always popped first.
+type nodeHeap []nodeDistance
+
+func (h nodeHeap) Len() int            { return len(h) }
+func (h nodeHeap) Less(i, j int) bool  { return h[i].distSq < h[j].distSq }
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(nodeDistance)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// A candidate result, along with its squared distance to the search point.
+type candidate struct {
+	data     interface{}
+	position Point
+	distSq   float64
+}
+
+// Max-heap of candidate, bounded to size k, so the current worst of the
+// k-best candidates is always at the root and can be evicted in O(log k).
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int            { return len(h) }
+func (h candidateHeap) Less(i, j int) bool  { return h[i].distSq > h[j].distSq }
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// Implements the best-first k-nearest search shared by KNearest and
+// KNearestWithin. A min-heap of nodes (keyed by distance to p) is expanded
+// closest-first, while a bounded max-heap of the k best candidates seen so
+// far lets the search stop as soon as no unexplored node can possibly beat
+// the current k-th best.
+func knearestInternal(qt *Quadtree, p Point, k int, maxDistSq float64) []interface{} {
+	if k <= 0 {
+		return []interface{}{}
+	}
+
+	nodes := &nodeHeap{{qt.root, sqDistToRect(p, qt.root.bounds)}}
+	heap.Init(nodes)
+	best := &candidateHeap{}
+
+	for nodes.Len() > 0 {
+		next := heap.Pop(nodes).(nodeDistance)
+		if next.distSq > maxDistSq {
+			// Every remaining node is at least this far away, so nothing
+			// left in the heap can be within range either.
+			break
+		}
+		if best.Len() >= k && next.distSq > (*best)[0].distSq {
+			// Every remaining node is farther than our current k-th best
+			// candidate, so none of them can improve the result.
+			break
+		}
+
+		// rectItems can live on this node whether it's a leaf or a branch, so
+		// consider them regardless of which case below applies.
+		for _, e := range next.n.rectItems {
+			considerCandidate(best, k, maxDistSq, candidate{e.data, e.position, sqDistToRect(p, e.rect)})
+		}
+
+		if next.n.items != nil {
+			for _, e := range next.n.items {
+				considerCandidate(best, k, maxDistSq, candidate{e.data, e.position, sqDist(p, e.position)})
+			}
+		} else {
+			pushChildNode(nodes, next.n.ul, p)
+			pushChildNode(nodes, next.n.ur, p)
+			pushChildNode(nodes, next.n.ll, p)
+			pushChildNode(nodes, next.n.lr, p)
+		}
+	}
+
+	candidates := make([]candidate, best.Len())
+	for i := len(candidates) - 1; i >= 0; i-- {
+		candidates[i] = heap.Pop(best).(candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distSq < candidates[j].distSq
+	})
+
+	items := make([]interface{}, len(candidates))
+	for i, c := range candidates {
+		items[i] = c.data
+	}
+	return items
+}
+
+func pushChildNode(nodes *nodeHeap, n *node, p Point) {
+	if n != nil {
+		heap.Push(nodes, nodeDistance{n, sqDistToRect(p, n.bounds)})
+	}
+}
+
+// Adds c to the bounded best-candidates heap if it's within maxDistSq and
+// either there's still room for it, or it beats the current k-th best.
+func considerCandidate(best *candidateHeap, k int, maxDistSq float64, c candidate) {
+	if c.distSq > maxDistSq {
+		return
+	}
+	if best.Len() < k {
+		heap.Push(best, c)
+	} else if c.distSq < (*best)[0].distSq {
+		heap.Pop(best)
+		heap.Push(best, c)
+	}
+}
+
+// Returns the squared distance from p to the nearest point of r, or 0 if p
+// is inside r.
+func sqDistToRect(p Point, r Rect) float64 {
+	dx := 0.0
+	if p.X < r.X {
+		dx = r.X - p.X
+	} else if p.X > r.X+r.Width {
+		dx = p.X - (r.X + r.Width)
+	}
+	dy := 0.0
+	if p.Y < r.Y {
+		dy = r.Y - p.Y
+	} else if p.Y > r.Y+r.Height {
+		dy = p.Y - (r.Y + r.Height)
+	}
+	return dx*dx + dy*dy
+}
+
+// Returns the squared distance between two points.
+func sqDist(a, b Point) float64 {
+	dx := a.X - b.X
+	dy := a.Y - b.Y
+	return dx*dx + dy*dy
 }
 
 // Adds the data to the tree with the given position.
@@ -126,7 +412,7 @@ func (qt *Quadtree) Add(data interface{}, position Point) (err error) {
 		// If the root node can't contain this data, signal error.
 		return fmt.Errorf("Add failed: position outside bounds of tree.")
 	}
-	item := treeEntry{position, data}
+	item := treeEntry{position: position, data: data}
 	addInternal(qt, qt.root, item)
 	qt.size += 1
 	return err
@@ -151,10 +437,10 @@ func addInternal(qt *Quadtree, node *node, item treeEntry) {
 		// This node is already at max capacity, so we need to split it into
 		// child nodes.
 		ul, ur, ll, lr := node.bounds.quadrants()
-		node.ul = newNode(node, ul)
-		node.ur = newNode(node, ur)
-		node.ll = newNode(node, ll)
-		node.lr = newNode(node, lr)
+		node.ul = newNode(qt, node, ul)
+		node.ur = newNode(qt, node, ur)
+		node.ll = newNode(qt, node, ll)
+		node.lr = newNode(qt, node, lr)
 		items := node.items
 		node.items = nil
 		for _, i := range items {
@@ -180,12 +466,398 @@ func addInternal(qt *Quadtree, node *node, item treeEntry) {
 	}
 }
 
-func newNode(parent *node, bounds Rect) *node {
-	return &node{
-		bounds: bounds,
-		depth:  parent.depth + 1,
-		items:  make([]treeEntry, 0, 4),
+// Removes the first item found at position whose data matches the given
+// value. Returns whether a matching item was found and removed. If several
+// items at position match data, only the first one encountered is removed.
+func (qt *Quadtree) Remove(data interface{}, position Point) bool {
+	if !qt.root.bounds.Contains(position) {
+		return false
+	}
+	if !removeInternal(qt, qt.root, position, data) {
+		return false
+	}
+	qt.size -= 1
+	return true
+}
+
+// Recurses down the tree to find the node holding the item at position, then
+// removes it and collapses any siblings that now fit back into their parent.
+func removeInternal(qt *Quadtree, node *node, position Point, data interface{}) bool {
+	quadrant := whichQuadrant(node.bounds, position)
+	if quadrant == quadrantNone {
+		return false
+	}
+
+	if node.items != nil {
+		for i, e := range node.items {
+			if e.position == position && e.data == data {
+				node.items = append(node.items[:i], node.items[i+1:]...)
+				collapseInternal(qt, node.parent)
+				return true
+			}
+		}
+		return false
+	}
+
+	switch quadrant {
+	case quadrantUpperLeft:
+		return removeInternal(qt, node.ul, position, data)
+	case quadrantUpperRight:
+		return removeInternal(qt, node.ur, position, data)
+	case quadrantLowerLeft:
+		return removeInternal(qt, node.ll, position, data)
+	case quadrantLowerRight:
+		return removeInternal(qt, node.lr, position, data)
+	}
+	return false
+}
+
+// The inverse of the split performed in addInternal: if all four children of
+// node are leaves whose items would fit in a single node, merge them back
+// into node and drop the children. Collapsing can then cascade up to the
+// grandparent, since removing this level may free up the one above it.
+func collapseInternal(qt *Quadtree, n *node) {
+	if n == nil || n.ul == nil {
+		// Either we've reached above the root, or n is already a leaf.
+		return
 	}
+	if n.ul.items == nil || n.ur.items == nil || n.ll.items == nil || n.lr.items == nil {
+		// At least one child is itself split into grandchildren, so this
+		// level can't be collapsed yet.
+		return
+	}
+
+	total := len(n.ul.items) + len(n.ur.items) + len(n.ll.items) + len(n.lr.items)
+	if total > qt.maxItemsPerNode {
+		return
+	}
+
+	merged := n.itemsBuf[:0]
+	if cap(merged) < total {
+		merged = make([]treeEntry, 0, total)
+	}
+	children := [4]*node{n.ul, n.ur, n.ll, n.lr}
+	for _, c := range children {
+		merged = append(merged, c.items...)
+		// Any AddRect'd items stored at a child (rather than recursed
+		// further) belong with the parent now that the child is gone.
+		n.rectItems = append(n.rectItems, c.rectItems...)
+		qt.putNode(c)
+	}
+	n.items = merged
+	n.ul, n.ur, n.ll, n.lr = nil, nil, nil, nil
+
+	collapseInternal(qt, n.parent)
+}
+
+// Moves the item with the given data from one position to another. Returns
+// an error if no matching item is found at from, or if to falls outside the
+// bounds of the tree.
+func (qt *Quadtree) Move(data interface{}, from, to Point) error {
+	if !qt.root.bounds.Contains(to) {
+		// Validate to before removing anything, so a failed Move leaves
+		// the tree untouched instead of losing the item.
+		return fmt.Errorf("Move failed: position outside bounds of tree.")
+	}
+	if !qt.Remove(data, from) {
+		return fmt.Errorf("Move failed: no item found with the given data at the given position.")
+	}
+	// to is already known to be within bounds, so this cannot fail.
+	return qt.Add(data, to)
+}
+
+// Adds the data to the tree with the given axis-aligned bounding box. Unlike
+// Add, the item is stored at the deepest existing node whose bounds fully
+// contain r (a "loose" placement), rather than being forced into a single
+// quadrant, so a rect that straddles a quadrant boundary isn't duplicated
+// into children. The item stays at that node if the node later splits.
+// Query returns any AddRect'd item whose rect overlaps the query bounds.
+func (qt *Quadtree) AddRect(data interface{}, r Rect) error {
+	if !containsRect(qt.root.bounds, r) {
+		return fmt.Errorf("AddRect failed: rect outside bounds of tree.")
+	}
+	addRectInternal(qt, qt.root, treeEntry{rect: r, data: data})
+	qt.size += 1
+	return nil
+}
+
+// Recurses into whichever child quadrant, if any, fully contains item.rect.
+// Once no child can (or node has no children to recurse into), node is the
+// deepest node fully containing the rect, so the item is stored there.
+func addRectInternal(qt *Quadtree, n *node, item treeEntry) {
+	if n.items == nil && n.depth < qt.maxDepth {
+		for _, child := range [4]*node{n.ul, n.ur, n.ll, n.lr} {
+			if containsRect(child.bounds, item.rect) {
+				addRectInternal(qt, child, item)
+				return
+			}
+		}
+	}
+	n.rectItems = append(n.rectItems, item)
+}
+
+// Returns whether outer fully contains inner.
+func containsRect(outer, inner Rect) bool {
+	return inner.X >= outer.X &&
+		inner.Y >= outer.Y &&
+		inner.X+inner.Width <= outer.X+outer.Width &&
+		inner.Y+inner.Height <= outer.Y+outer.Height
+}
+
+// Tags identifying the kind of node that follows in the binary encoding.
+const (
+	nodeTagLeaf   byte = 0
+	nodeTagBranch byte = 1
+)
+
+// Encodes the tree to a binary format suitable for persisting a pre-built
+// spatial index to disk: maxDepth, maxItemsPerNode and the root bounds,
+// followed by a pre-order traversal of the tree. Each node is written as a
+// tag byte, then its rectItems (a count, then each item's Rect and payload;
+// rectItems can be non-empty on a leaf or a branch), and, for a leaf, its
+// items (a count, then each item's X, Y and payload).
+//
+// Each item's data is encoded by calling its encoding.BinaryMarshaler, so
+// MarshalBinary fails if any stored item (point- or AddRect-valued) doesn't
+// implement that interface.
+func (qt *Quadtree) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	writeVarint(&buf, int64(qt.maxDepth))
+	writeVarint(&buf, int64(qt.maxItemsPerNode))
+	writeRect(&buf, qt.root.bounds)
+	if err := marshalNode(&buf, qt.root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a tree previously produced by MarshalBinary. Since the payload
+// type was erased during encoding, the caller must supply decode to turn
+// each item's encoded bytes back into the original interface{}.
+func UnmarshalBinary(b []byte, decode func([]byte) (interface{}, error)) (*Quadtree, error) {
+	r := bytes.NewReader(b)
+
+	maxDepth, err := readVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	maxItemsPerNode, err := readVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	bounds, err := readRect(r)
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+
+	qt, err := NewQuadtree(bounds, int(maxDepth), int(maxItemsPerNode))
+	if err != nil {
+		return nil, err
+	}
+	size, err := unmarshalNode(r, qt, qt.root, decode)
+	if err != nil {
+		return nil, err
+	}
+	qt.size = size
+	return qt, nil
+}
+
+func marshalNode(buf *bytes.Buffer, n *node) error {
+	if n.items == nil {
+		buf.WriteByte(nodeTagBranch)
+		if err := marshalRectItems(buf, n.rectItems); err != nil {
+			return err
+		}
+		for _, child := range [4]*node{n.ul, n.ur, n.ll, n.lr} {
+			if err := marshalNode(buf, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf.WriteByte(nodeTagLeaf)
+	if err := marshalRectItems(buf, n.rectItems); err != nil {
+		return err
+	}
+
+	writeVarint(buf, int64(len(n.items)))
+	for _, e := range n.items {
+		payload, err := marshalPayload(e.data)
+		if err != nil {
+			return err
+		}
+		writeFloat64(buf, e.position.X)
+		writeFloat64(buf, e.position.Y)
+		writeVarint(buf, int64(len(payload)))
+		buf.Write(payload)
+	}
+	return nil
+}
+
+func marshalRectItems(buf *bytes.Buffer, entries []treeEntry) error {
+	writeVarint(buf, int64(len(entries)))
+	for _, e := range entries {
+		payload, err := marshalPayload(e.data)
+		if err != nil {
+			return err
+		}
+		writeRect(buf, e.rect)
+		writeVarint(buf, int64(len(payload)))
+		buf.Write(payload)
+	}
+	return nil
+}
+
+func marshalPayload(data interface{}) ([]byte, error) {
+	marshaler, ok := data.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("MarshalBinary failed: item data does not implement encoding.BinaryMarshaler")
+	}
+	payload, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalBinary failed: %v", err)
+	}
+	return payload, nil
+}
+
+func unmarshalNode(r *bytes.Reader, qt *Quadtree, n *node, decode func([]byte) (interface{}, error)) (int, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+
+	rectCount, err := readVarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	for i := int64(0); i < rectCount; i++ {
+		rect, err := readRect(r)
+		if err != nil {
+			return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+		}
+		data, err := readPayload(r, decode)
+		if err != nil {
+			return 0, err
+		}
+		n.rectItems = append(n.rectItems, treeEntry{rect: rect, data: data})
+	}
+	total := int(rectCount)
+
+	if tag == nodeTagBranch {
+		ul, ur, ll, lr := n.bounds.quadrants()
+		n.ul = newNode(qt, n, ul)
+		n.ur = newNode(qt, n, ur)
+		n.ll = newNode(qt, n, ll)
+		n.lr = newNode(qt, n, lr)
+		n.items = nil
+
+		for _, child := range [4]*node{n.ul, n.ur, n.ll, n.lr} {
+			count, err := unmarshalNode(r, qt, child, decode)
+			if err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	}
+
+	count, err := readVarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	for i := int64(0); i < count; i++ {
+		x, err := readFloat64(r)
+		if err != nil {
+			return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+		}
+		y, err := readFloat64(r)
+		if err != nil {
+			return 0, fmt.Errorf("UnmarshalBinary failed: %v", err)
+		}
+		data, err := readPayload(r, decode)
+		if err != nil {
+			return 0, err
+		}
+		n.items = append(n.items, treeEntry{position: Point{x, y}, data: data})
+	}
+	return total + int(count), nil
+}
+
+func readPayload(r *bytes.Reader, decode func([]byte) (interface{}, error)) (interface{}, error) {
+	payloadLen, err := readVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	data, err := decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary failed: %v", err)
+	}
+	return data, nil
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeFloat64(buf *bytes.Buffer, f float64) {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], math.Float64bits(f))
+	buf.Write(tmp[:])
+}
+
+func writeRect(buf *bytes.Buffer, r Rect) {
+	writeFloat64(buf, r.X)
+	writeFloat64(buf, r.Y)
+	writeFloat64(buf, r.Width)
+	writeFloat64(buf, r.Height)
+}
+
+func readVarint(r *bytes.Reader) (int64, error) {
+	return binary.ReadVarint(r)
+}
+
+func readFloat64(r *bytes.Reader) (float64, error) {
+	var tmp [8]byte
+	if _, err := io.ReadFull(r, tmp[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(tmp[:])), nil
+}
+
+func readRect(r *bytes.Reader) (Rect, error) {
+	x, err := readFloat64(r)
+	if err != nil {
+		return Rect{}, err
+	}
+	y, err := readFloat64(r)
+	if err != nil {
+		return Rect{}, err
+	}
+	w, err := readFloat64(r)
+	if err != nil {
+		return Rect{}, err
+	}
+	h, err := readFloat64(r)
+	if err != nil {
+		return Rect{}, err
+	}
+	return Rect{x, y, w, h}, nil
+}
+
+func newNode(qt *Quadtree, parent *node, bounds Rect) *node {
+	n := qt.getNode()
+	n.bounds = bounds
+	n.depth = parent.depth + 1
+	n.items = n.itemsBuf[:0]
+	n.parent = parent
+	return n
 }
 
 // Returns which quadrant the Point p is inside Rect r
@@ -1,6 +1,7 @@
 package quadtree
 
 import (
+	"encoding/binary"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"testing"
@@ -27,6 +28,12 @@ func ToData(d interface{}) *TestData {
  	return data
 }
 
+func (d *TestData) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(d.id))
+	return b, nil
+}
+
 func BenchmarkAdd(b *testing.B) {
 	bounds := Rect{0, 0, 1, 1}
 	qt, _ := NewQuadtree(bounds, 10, 10)
@@ -65,6 +72,27 @@ func BenchmarkQuery(b *testing.B) {
 	}
 }
 
+// BenchmarkAddRemove exercises continuous Add/Remove, as opposed to
+// BenchmarkAdd's build-once workload. Each item is removed right after it
+// is added, so the tree never holds more than one item and never splits.
+func BenchmarkAddRemove(b *testing.B) {
+	bounds := Rect{0, 0, 1, 1}
+	qt, _ := NewQuadtree(bounds, 10, 10)
+
+	data := make([]*TestData, b.N, b.N)
+	positions := make([]Point, b.N, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = NewData(i)
+		positions[i] = Point{rand.Float64(), rand.Float64()}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qt.Add(data[i], positions[i])
+		qt.Remove(data[i], positions[i])
+	}
+}
+
 func TestQueryIterative_returnsCorrectPosition(t *testing.T) {
 	qt, _ := NewQuadtree(Rect{-1, -1, 1, 1}, 2, 2)
 
@@ -235,3 +263,284 @@ func TestNewQuadTree_succeedsWhenValidConfiguration(t *testing.T) {
 	assert.NotNil(t, qt)
 	assert.Nil(t, err)
 }
+
+func TestRemove_removesItemAndDecrementsSize(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	d1 := NewDataWithPosition(1, Point{0.2, 0.2})
+	qt.Add(d1, d1.position)
+	assert.Equal(t, 1, qt.Size())
+
+	removed := qt.Remove(d1, d1.position)
+	assert.True(t, removed)
+	assert.Equal(t, 0, qt.Size())
+	assert.Len(t, qt.Query(Rect{0, 0, 1, 1}), 0)
+}
+
+func TestRemove_whenNoMatchingItem_returnsFalse(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	d1 := NewDataWithPosition(1, Point{0.2, 0.2})
+	qt.Add(d1, d1.position)
+
+	removed := qt.Remove(NewData(2), Point{0.2, 0.2})
+	assert.False(t, removed)
+	assert.Equal(t, 1, qt.Size())
+}
+
+func TestRemove_whenMultipleItemsShareAPosition_removesOnlyFirstMatch(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	d1 := NewDataWithPosition(1, Point{0.2, 0.2})
+	// The same data stored twice at one position, so both entries match.
+	d2 := d1
+	qt.Add(d1, d1.position)
+	qt.Add(d2, d2.position)
+
+	removed := qt.Remove(d1, d1.position)
+	assert.True(t, removed)
+	assert.Equal(t, 1, qt.Size())
+	items := qt.Query(Rect{0, 0, 1, 1})
+	assert.ElementsMatch(t, [1]*TestData{d2}, items)
+}
+
+func TestRemove_collapsesSiblingsBackIntoParent(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 4, 4}, 5, 2)
+
+	d1 := NewDataWithPosition(1, Point{0.5, 0.5})
+	d2 := NewDataWithPosition(2, Point{3.5, 0.5})
+	d3 := NewDataWithPosition(3, Point{0.5, 3.5})
+	qt.Add(d1, d1.position)
+	qt.Add(d2, d2.position)
+	// A third item exceeds maxItemsPerNode and forces the root to split
+	// into four child nodes.
+	qt.Add(d3, d3.position)
+	d4 := NewDataWithPosition(4, Point{3.5, 3.5})
+	qt.Add(d4, d4.position)
+	assert.NotNil(t, qt.root.ul)
+
+	// Removing two items brings the total back down to maxItemsPerNode,
+	// so the children should collapse back into the root.
+	assert.True(t, qt.Remove(d4, d4.position))
+	assert.True(t, qt.Remove(d3, d3.position))
+
+	assert.Nil(t, qt.root.ul)
+	assert.Nil(t, qt.root.ur)
+	assert.Nil(t, qt.root.ll)
+	assert.Nil(t, qt.root.lr)
+	items := qt.Query(Rect{0, 0, 4, 4})
+	assert.ElementsMatch(t, [2]*TestData{d1, d2}, items)
+}
+
+func TestMove_movesItemToNewPosition(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	d1 := NewDataWithPosition(1, Point{0.2, 0.2})
+	qt.Add(d1, d1.position)
+
+	err := qt.Move(d1, d1.position, Point{0.8, 0.8})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, qt.Size())
+
+	items := qt.Query(Rect{0, 0, 1, 1})
+	assert.ElementsMatch(t, [1]*TestData{d1}, items)
+	items = qt.Query(Rect{0, 0, 0.5, 0.5})
+	assert.Len(t, items, 0)
+}
+
+func TestMove_whenFromDoesNotMatch_returnsError(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	err := qt.Move(NewData(1), Point{0.2, 0.2}, Point{0.8, 0.8})
+	assert.NotNil(t, err)
+}
+
+func TestKNearest_returnsClosestItemsSortedByDistance(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	near := NewDataWithPosition(1, Point{5, 5})
+	mid := NewDataWithPosition(2, Point{5, 7})
+	far := NewDataWithPosition(3, Point{0, 0})
+	qt.Add(near, near.position)
+	qt.Add(mid, mid.position)
+	qt.Add(far, far.position)
+
+	items := qt.KNearest(Point{5, 5.5}, 2)
+	assert.Equal(t, []interface{}{near, mid}, items)
+}
+
+func TestKNearest_whenKExceedsSize_returnsAllItems(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	d1 := NewDataWithPosition(1, Point{1, 1})
+	d2 := NewDataWithPosition(2, Point{9, 9})
+	qt.Add(d1, d1.position)
+	qt.Add(d2, d2.position)
+
+	items := qt.KNearest(Point{0, 0}, 5)
+	assert.Len(t, items, 2)
+}
+
+func TestKNearest_onEmptyTree_returnsNoItems(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	items := qt.KNearest(Point{5, 5}, 3)
+	assert.Len(t, items, 0)
+}
+
+func TestKNearestWithin_excludesItemsBeyondMaxDist(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	near := NewDataWithPosition(1, Point{5, 5})
+	far := NewDataWithPosition(2, Point{9, 9})
+	qt.Add(near, near.position)
+	qt.Add(far, far.position)
+
+	items := qt.KNearestWithin(Point{5, 5}, 2, 1.0)
+	assert.Equal(t, []interface{}{near}, items)
+}
+
+func TestKNearest_considersAddRectItems(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	rectData := NewData(1)
+	qt.AddRect(rectData, Rect{4, 4, 2, 2})
+
+	pointData := NewDataWithPosition(2, Point{9, 9})
+	qt.Add(pointData, pointData.position)
+
+	items := qt.KNearest(Point{5, 5}, 1)
+	assert.Equal(t, []interface{}{rectData}, items)
+}
+
+func TestClear_removesAllItemsAndResetsSize(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 4, 4}, 5, 2)
+
+	qt.Add(NewDataWithPosition(1, Point{0.5, 0.5}), Point{0.5, 0.5})
+	qt.Add(NewDataWithPosition(2, Point{3.5, 0.5}), Point{3.5, 0.5})
+	// Forces the root to split into child nodes.
+	qt.Add(NewDataWithPosition(3, Point{0.5, 3.5}), Point{0.5, 3.5})
+	qt.Add(NewDataWithPosition(4, Point{3.5, 3.5}), Point{3.5, 3.5})
+	assert.NotNil(t, qt.root.ul)
+
+	qt.Clear()
+
+	assert.Equal(t, 0, qt.Size())
+	assert.Nil(t, qt.root.ul)
+	assert.Len(t, qt.Query(Rect{0, 0, 4, 4}), 0)
+}
+
+func TestClear_treeIsUsableAfterClearing(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	qt.Add(NewData(1), Point{0.2, 0.2})
+	qt.Clear()
+
+	d := NewDataWithPosition(2, Point{0.5, 0.5})
+	err := qt.Add(d, d.position)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, qt.Size())
+	assert.ElementsMatch(t, [1]*TestData{d}, qt.Query(Rect{0, 0, 1, 1}))
+}
+
+func TestAddRect_outsideBoundsGeneratesError(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 5)
+
+	err := qt.AddRect(NewData(1), Rect{8, 8, 5, 5})
+	assert.NotNil(t, err)
+}
+
+func TestAddRect_queryReturnsOverlappingRect(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 2)
+
+	d := NewData(1)
+	err := qt.AddRect(d, Rect{1, 1, 2, 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, qt.Size())
+
+	items := qt.Query(Rect{2, 2, 1, 1})
+	assert.ElementsMatch(t, [1]*TestData{d}, items)
+
+	items = qt.Query(Rect{5, 5, 1, 1})
+	assert.Len(t, items, 0)
+}
+
+func TestAddRect_whenRectStraddlesQuadrantBoundary_isNotDuplicated(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 5, 1)
+
+	// Forces the root to split into four quadrants of size 5x5 each.
+	qt.Add(NewData(1), Point{1, 1})
+	qt.Add(NewData(2), Point{9, 9})
+
+	straddling := NewData(3)
+	err := qt.AddRect(straddling, Rect{4, 4, 2, 2})
+	assert.Nil(t, err)
+
+	items := qt.Query(Rect{0, 0, 10, 10})
+	assert.Len(t, items, 3)
+	count := 0
+	for _, item := range items {
+		if item == straddling {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestMarshalBinary_roundTripsTree(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 3, 2)
+
+	d1 := NewDataWithPosition(1, Point{1, 1})
+	d2 := NewDataWithPosition(2, Point{9, 9})
+	d3 := NewDataWithPosition(3, Point{1, 9})
+	qt.Add(d1, d1.position)
+	qt.Add(d2, d2.position)
+	qt.Add(d3, d3.position)
+	d4 := NewData(4)
+	qt.AddRect(d4, Rect{4, 4, 2, 2})
+
+	encoded, err := qt.MarshalBinary()
+	assert.Nil(t, err)
+
+	decode := func(b []byte) (interface{}, error) {
+		return &TestData{id: int(binary.BigEndian.Uint64(b))}, nil
+	}
+	restored, err := UnmarshalBinary(encoded, decode)
+	assert.Nil(t, err)
+	assert.Equal(t, qt.Size(), restored.Size())
+
+	items := restored.Query(Rect{0, 0, 10, 10})
+	ids := make([]int, len(items))
+	for i, item := range items {
+		ids[i] = ToData(item).id
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3, 4}, ids)
+}
+
+func TestMarshalBinary_whenRectDataDoesNotImplementBinaryMarshaler_returnsError(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 3, 2)
+	qt.AddRect(42, Rect{1, 1, 2, 2})
+
+	_, err := qt.MarshalBinary()
+	assert.NotNil(t, err)
+}
+
+func TestMarshalBinary_whenDataDoesNotImplementBinaryMarshaler_returnsError(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 10, 10}, 3, 2)
+	qt.Add(42, Point{1, 1})
+
+	_, err := qt.MarshalBinary()
+	assert.NotNil(t, err)
+}
+
+func TestMove_whenToIsOutsideBounds_returnsError(t *testing.T) {
+	qt, _ := NewQuadtree(Rect{0, 0, 1, 1}, 5, 5)
+
+	d1 := NewDataWithPosition(1, Point{0.2, 0.2})
+	qt.Add(d1, d1.position)
+
+	err := qt.Move(d1, d1.position, Point{5, 5})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, qt.Size())
+	assert.ElementsMatch(t, [1]*TestData{d1}, qt.Query(Rect{0, 0, 1, 1}))
+}